@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envCacheEnabled    = "MIST_CACHE_ENABLED"
+	envCacheBackend    = "MIST_CACHE_BACKEND" // "memory" (default), "filesystem", "redis"
+	envCacheDefaultTTL = "MIST_CACHE_DEFAULT_TTL"
+	envCachePathTTLs   = "MIST_CACHE_PATH_TTLS" // "prefix=ttl,prefix2=ttl2"
+	envCacheMaxEntries = "MIST_CACHE_MAX_ENTRIES"
+	envCacheDir        = "MIST_CACHE_DIR"
+	envCacheRedisAddr  = "MIST_CACHE_REDIS_ADDR"
+)
+
+// CacheConfig controls the response caching middleware. It is populated
+// from environment variables rather than the YAML file, matching how the
+// rest of the cache subsystem is operated per-instance.
+type CacheConfig struct {
+	Enabled    bool
+	Backend    string
+	DefaultTTL time.Duration
+	PathTTLs   map[string]time.Duration
+	MaxEntries int
+	Dir        string
+	RedisAddr  string
+}
+
+func loadCacheConfigFromEnv() CacheConfig {
+	cfg := CacheConfig{
+		Enabled:    os.Getenv(envCacheEnabled) == "true",
+		Backend:    strings.TrimSpace(os.Getenv(envCacheBackend)),
+		DefaultTTL: 60 * time.Second,
+		PathTTLs:   map[string]time.Duration{},
+		MaxEntries: 10000,
+		Dir:        strings.TrimSpace(os.Getenv(envCacheDir)),
+		RedisAddr:  strings.TrimSpace(os.Getenv(envCacheRedisAddr)),
+	}
+
+	if v := strings.TrimSpace(os.Getenv(envCacheDefaultTTL)); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.DefaultTTL = d
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv(envCacheMaxEntries)); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxEntries = n
+		}
+	}
+	for _, pair := range strings.Split(os.Getenv(envCachePathTTLs), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		prefix, ttlStr, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if d, err := time.ParseDuration(strings.TrimSpace(ttlStr)); err == nil {
+			cfg.PathTTLs[strings.TrimSpace(prefix)] = d
+		}
+	}
+
+	return cfg
+}
+
+func (c CacheConfig) ttlFor(path string) time.Duration {
+	ttl := c.DefaultTTL
+	matched := ""
+	for prefix, d := range c.PathTTLs {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+			ttl = d
+		}
+	}
+	return ttl
+}
+
+// cacheEntry is a stored GET response, kept long enough to answer
+// subsequent requests for the same resource without hitting Mist again.
+type cacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	ETag       string
+	StoredAt   time.Time
+	TTL        time.Duration
+}
+
+func (e *cacheEntry) fresh() bool {
+	return time.Since(e.StoredAt) < e.TTL
+}
+
+// cacheStore is implemented by the in-memory, filesystem, and Redis
+// backends so operators can pick whatever fits their deployment.
+type cacheStore interface {
+	Get(key string) (*cacheEntry, bool)
+	Set(key string, entry *cacheEntry)
+}
+
+func newCacheStore(cfg CacheConfig) (cacheStore, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return newMemoryCacheStore(cfg.MaxEntries), nil
+	case "filesystem":
+		return newFilesystemCacheStore(cfg.Dir)
+	case "redis":
+		return newRedisCacheStore(cfg.RedisAddr)
+	default:
+		return nil, fmt.Errorf("未知のキャッシュバックエンドです: %s", cfg.Backend)
+	}
+}
+
+// memoryCacheStore is a fixed-capacity LRU cache guarded by a mutex.
+type memoryCacheStore struct {
+	mu       sync.Mutex
+	maxItems int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newMemoryCacheStore(maxItems int) *memoryCacheStore {
+	if maxItems <= 0 {
+		maxItems = 10000
+	}
+	return &memoryCacheStore{
+		maxItems: maxItems,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (s *memoryCacheStore) Get(key string) (*cacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return el.Value.(*memoryCacheItem).entry, true
+}
+
+func (s *memoryCacheStore) Set(key string, entry *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		s.ll.MoveToFront(el)
+		return
+	}
+
+	el := s.ll.PushFront(&memoryCacheItem{key: key, entry: entry})
+	s.items[key] = el
+	for s.ll.Len() > s.maxItems {
+		oldest := s.ll.Back()
+		if oldest == nil {
+			break
+		}
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// cacheKey identifies a response by the exact request it answers: method,
+// full URL, and the caller's credential (Authorization and X-Tenant), so
+// tenants never see each other's cached data.
+func cacheKey(req *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte("|"))
+	h.Write([]byte(req.URL.String()))
+	h.Write([]byte("|"))
+	h.Write([]byte(req.Header.Get("Authorization")))
+	h.Write([]byte("|"))
+	h.Write([]byte(req.Header.Get("X-Tenant")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isCacheable(header http.Header) bool {
+	cc := header.Get("Cache-Control")
+	return !strings.Contains(cc, "no-store")
+}
+
+// cachingResponseRecorder captures a response entirely in memory instead of
+// forwarding it to the real client. This lets cachingMiddleware see the
+// full upstream outcome (in particular, a bare 304 with no body) before
+// deciding what the caller should actually receive.
+type cachingResponseRecorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newCachingResponseRecorder() *cachingResponseRecorder {
+	return &cachingResponseRecorder{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (r *cachingResponseRecorder) Header() http.Header { return r.header }
+
+func (r *cachingResponseRecorder) WriteHeader(code int) { r.statusCode = code }
+
+func (r *cachingResponseRecorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+// writeCachedEntry sends a stored entry to the real client, tagging it with
+// the given X-Cache value.
+func writeCachedEntry(rw http.ResponseWriter, entry *cacheEntry, cacheStatus string) {
+	for k, values := range entry.Header {
+		for _, v := range values {
+			rw.Header().Add(k, v)
+		}
+	}
+	rw.Header().Set("X-Cache", cacheStatus)
+	rw.WriteHeader(entry.StatusCode)
+	_, _ = rw.Write(entry.Body)
+}
+
+// cachingMiddleware serves fresh GET responses straight from the cache. A
+// stale entry is still served immediately (stale-while-revalidate): the
+// caller gets the cached body right away, and the real revalidation against
+// Mist happens in a background goroutine that updates the store for next
+// time. A cold cache blocks on the upstream round-trip, same as before, since
+// there's nothing stale to serve. It sits in front of the reverse proxy,
+// alongside the other cross-cutting middlewares.
+func cachingMiddleware(next http.Handler, store cacheStore, cfg CacheConfig) http.Handler {
+	revalidating := revalidationTracker{inFlight: make(map[string]struct{})}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !cfg.Enabled || req.Method != http.MethodGet || req.Header.Get("X-Cache-Bypass") != "" || isWebSocketUpgrade(req) {
+			cacheRequestsTotal.WithLabelValues("bypass").Inc()
+			rw.Header().Set("X-Cache", "BYPASS")
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		key := cacheKey(req)
+		entry, ok := store.Get(key)
+
+		if ok && entry.fresh() {
+			cacheRequestsTotal.WithLabelValues("hit").Inc()
+			writeCachedEntry(rw, entry, "HIT")
+			return
+		}
+
+		if ok {
+			cacheRequestsTotal.WithLabelValues("stale").Inc()
+			writeCachedEntry(rw, entry, "STALE")
+			revalidating.run(key, func() {
+				revalidate(next, store, cfg, req, key, entry)
+			})
+			return
+		}
+
+		cacheRequestsTotal.WithLabelValues("miss").Inc()
+		rec := newCachingResponseRecorder()
+		next.ServeHTTP(rec, req)
+
+		if rec.statusCode == http.StatusOK && isCacheable(rec.header) {
+			fresh := newCacheEntryFromRecorder(rec, cfg.ttlFor(req.URL.Path))
+			store.Set(key, fresh)
+			writeCachedEntry(rw, fresh, "MISS")
+			return
+		}
+
+		for k, values := range rec.header {
+			for _, v := range values {
+				rw.Header().Add(k, v)
+			}
+		}
+		rw.Header().Set("X-Cache", "MISS")
+		rw.WriteHeader(rec.statusCode)
+		_, _ = rw.Write(rec.body.Bytes())
+	})
+}
+
+// revalidationTracker skips a background revalidation for a key that's
+// already in flight, so a burst of requests against the same stale entry
+// doesn't pile up redundant upstream round-trips.
+type revalidationTracker struct {
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+func (t *revalidationTracker) run(key string, fn func()) {
+	t.mu.Lock()
+	if _, busy := t.inFlight[key]; busy {
+		t.mu.Unlock()
+		return
+	}
+	t.inFlight[key] = struct{}{}
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.inFlight, key)
+			t.mu.Unlock()
+		}()
+		fn()
+	}()
+}
+
+// revalidate re-checks a stale entry against Mist with If-None-Match and
+// updates the store, independently of the client request that triggered it
+// (whose context is gone by the time this runs).
+func revalidate(next http.Handler, store cacheStore, cfg CacheConfig, req *http.Request, key string, entry *cacheEntry) {
+	revalReq := req.Clone(context.Background())
+	if entry.ETag != "" {
+		revalReq.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	rec := newCachingResponseRecorder()
+	next.ServeHTTP(rec, revalReq)
+
+	switch {
+	case rec.statusCode == http.StatusNotModified:
+		entry.StoredAt = time.Now()
+		store.Set(key, entry)
+	case rec.statusCode == http.StatusOK && isCacheable(rec.header):
+		store.Set(key, newCacheEntryFromRecorder(rec, cfg.ttlFor(req.URL.Path)))
+	}
+	// Any other outcome (error, non-cacheable) is dropped; the stale entry
+	// stays put and the next request tries revalidation again.
+}
+
+func newCacheEntryFromRecorder(rec *cachingResponseRecorder, ttl time.Duration) *cacheEntry {
+	return &cacheEntry{
+		StatusCode: rec.statusCode,
+		Header:     rec.header.Clone(),
+		Body:       append([]byte(nil), rec.body.Bytes()...),
+		ETag:       rec.header.Get("ETag"),
+		StoredAt:   time.Now(),
+		TTL:        ttl,
+	}
+}
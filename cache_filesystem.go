@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// filesystemCacheStore persists entries as one gob-encoded file per key
+// under Dir, so a cache survives process restarts on a single instance.
+type filesystemCacheStore struct {
+	dir string
+}
+
+func newFilesystemCacheStore(dir string) (*filesystemCacheStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("MIST_CACHE_DIR が設定されていません")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("キャッシュディレクトリの作成に失敗しました: %w", err)
+	}
+	return &filesystemCacheStore{dir: dir}, nil
+}
+
+func (s *filesystemCacheStore) pathFor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".gob")
+}
+
+func (s *filesystemCacheStore) Get(key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(s.pathFor(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (s *filesystemCacheStore) Set(key string, entry *cacheEntry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return
+	}
+	_ = os.WriteFile(s.pathFor(key), buf.Bytes(), 0o644)
+}
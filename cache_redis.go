@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisCacheStore backs the response cache with Redis so a fleet of
+// wrapper instances shares one cache and a restart doesn't cold-start it.
+type redisCacheStore struct {
+	client *redis.Client
+}
+
+func newRedisCacheStore(addr string) (*redisCacheStore, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("MIST_CACHE_REDIS_ADDR が設定されていません")
+	}
+	return &redisCacheStore{client: redis.NewClient(&redis.Options{Addr: addr})}, nil
+}
+
+func (s *redisCacheStore) Get(key string) (*cacheEntry, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, "mist-proxy:cache:"+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (s *redisCacheStore) Set(key string, entry *cacheEntry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Keep entries around long enough to serve stale-while-revalidate
+	// lookups after their TTL has lapsed, capped well beyond any sane TTL.
+	s.client.Set(ctx, "mist-proxy:cache:"+key, buf.Bytes(), entry.TTL+time.Hour)
+}
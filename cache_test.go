@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachingMiddlewareMissThenHit(t *testing.T) {
+	var upstreamHits int32
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&upstreamHits, 1)
+		rw.Header().Set("ETag", `"v1"`)
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("payload"))
+	})
+
+	store := newMemoryCacheStore(10)
+	cfg := CacheConfig{Enabled: true, DefaultTTL: time.Minute}
+	handler := cachingMiddleware(upstream, store, cfg)
+
+	rw1 := httptest.NewRecorder()
+	handler.ServeHTTP(rw1, httptest.NewRequest(http.MethodGet, "/api/v1/self", nil))
+	if got := rw1.Header().Get("X-Cache"); got != "MISS" {
+		t.Fatalf("first request X-Cache = %q, want MISS", got)
+	}
+	if rw1.Body.String() != "payload" {
+		t.Fatalf("first request body = %q, want %q", rw1.Body.String(), "payload")
+	}
+
+	rw2 := httptest.NewRecorder()
+	handler.ServeHTTP(rw2, httptest.NewRequest(http.MethodGet, "/api/v1/self", nil))
+	if got := rw2.Header().Get("X-Cache"); got != "HIT" {
+		t.Fatalf("second request X-Cache = %q, want HIT", got)
+	}
+	if rw2.Body.String() != "payload" {
+		t.Fatalf("second request body = %q, want %q", rw2.Body.String(), "payload")
+	}
+	if got := atomic.LoadInt32(&upstreamHits); got != 1 {
+		t.Fatalf("upstream was hit %d times, want 1 (second request should be served from cache)", got)
+	}
+}
+
+func TestCachingMiddlewareBypass(t *testing.T) {
+	var upstreamHits int32
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&upstreamHits, 1)
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	store := newMemoryCacheStore(10)
+	cfg := CacheConfig{Enabled: true, DefaultTTL: time.Minute}
+	handler := cachingMiddleware(upstream, store, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/self", nil)
+	req.Header.Set("X-Cache-Bypass", "1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	if got := rw.Header().Get("X-Cache"); got != "BYPASS" {
+		t.Fatalf("X-Cache = %q, want BYPASS", got)
+	}
+	if got := atomic.LoadInt32(&upstreamHits); got != 1 {
+		t.Fatalf("upstream was hit %d times, want 1", got)
+	}
+}
+
+// TestCachingMiddlewareStaleRevalidatesInBackground drives the
+// stale-while-revalidate path: a stale entry must be served to the caller
+// immediately, with the real revalidation against upstream happening
+// afterwards in a goroutine that updates the store.
+func TestCachingMiddlewareStaleRevalidatesInBackground(t *testing.T) {
+	reachedUpstream := make(chan struct{})
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(reachedUpstream)
+		rw.Header().Set("ETag", `"v2"`)
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("fresh-payload"))
+	})
+
+	store := newMemoryCacheStore(10)
+	cfg := CacheConfig{Enabled: true, DefaultTTL: time.Minute}
+	handler := cachingMiddleware(upstream, store, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/self", nil)
+	key := cacheKey(req)
+	store.Set(key, &cacheEntry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"ETag": []string{`"v1"`}},
+		Body:       []byte("stale-payload"),
+		ETag:       `"v1"`,
+		StoredAt:   time.Now().Add(-time.Hour),
+		TTL:        time.Minute,
+	})
+
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	elapsed := time.Since(start)
+
+	if got := rw.Header().Get("X-Cache"); got != "STALE" {
+		t.Fatalf("X-Cache = %q, want STALE", got)
+	}
+	if rw.Body.String() != "stale-payload" {
+		t.Fatalf("body = %q, want the stale cached payload served without waiting on upstream", rw.Body.String())
+	}
+
+	select {
+	case <-reachedUpstream:
+	case <-time.After(2 * time.Second):
+		t.Fatal("background revalidation never reached upstream")
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("request took %v to return; stale entries must be served without blocking on revalidation", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entry, ok := store.Get(key)
+		if ok && string(entry.Body) == "fresh-payload" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("store was never updated with the revalidated entry (got entry=%+v, ok=%v)", entry, ok)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envConfigFile = "MIST_CONFIG_FILE"
+
+// Config is the top-level YAML configuration for the proxy. It is entirely
+// optional: when MIST_CONFIG_FILE is unset, every subsystem falls back to
+// its built-in defaults and the single-tenant environment variables.
+type Config struct {
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Tenants   []TenantConfig  `yaml:"tenants"`
+}
+
+// loadConfig reads and parses the file named by MIST_CONFIG_FILE, if set.
+func loadConfig() (Config, error) {
+	var cfg Config
+
+	path := strings.TrimSpace(os.Getenv(envConfigFile))
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("設定ファイルの読み込みに失敗しました: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("設定ファイルの解析に失敗しました: %w", err)
+	}
+	return cfg, nil
+}
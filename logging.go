@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type upstreamStatusKey struct{}
+
+// withUpstreamStatusRecorder attaches a mutable cell to req's context that
+// the reverse proxy's ModifyResponse can fill in with the status Mist
+// actually returned. This works even though httputil.ReverseProxy clones
+// the request before calling Director/ModifyResponse, because Clone keeps
+// the same context.
+func withUpstreamStatusRecorder(req *http.Request) *http.Request {
+	var status int32
+	return req.WithContext(context.WithValue(req.Context(), upstreamStatusKey{}, &status))
+}
+
+func recordUpstreamStatus(req *http.Request, status int) {
+	if cell, ok := req.Context().Value(upstreamStatusKey{}).(*int32); ok {
+		atomic.StoreInt32(cell, int32(status))
+	}
+}
+
+func upstreamStatusFrom(req *http.Request) int {
+	if cell, ok := req.Context().Value(upstreamStatusKey{}).(*int32); ok {
+		return int(atomic.LoadInt32(cell))
+	}
+	return 0
+}
+
+// loggingMiddleware emits one structured JSON log line per request via
+// slog, and wraps the ResponseWriter so the rest of the stack can report
+// the final status code it wrote.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			remoteIP = req.RemoteAddr
+		}
+
+		req = withUpstreamStatusRecorder(req)
+		lrw := &loggingResponseWriter{ResponseWriter: rw, statusCode: http.StatusOK, path: req.URL.Path}
+		next.ServeHTTP(lrw, req)
+
+		slog.Info("request",
+			"method", req.Method,
+			"path", req.URL.Path,
+			"status", lrw.statusCode,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"upstream_status", upstreamStatusFrom(req),
+			"request_id", requestIDFrom(req),
+			"remote_ip", remoteIP,
+		)
+	})
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	path       string
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.statusCode = code
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+// Hijack lets WebSocket upgrades pass through loggingMiddleware: without
+// it, wrapping the ResponseWriter here would make it stop satisfying
+// http.Hijacker and httputil.ReverseProxy's built-in upgrade support would
+// fail. The hijacked connection is wrapped so upgraded connections still
+// get duration/byte logging and an idle timeout.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("基盤となるResponseWriterはハイジャックに対応していません")
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return newWSLoggingConn(conn, lrw.path), buf, nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -23,31 +23,74 @@ const (
 )
 
 func main() {
-	baseURL := strings.TrimSpace(os.Getenv(envBaseURL))
-	token := strings.TrimSpace(os.Getenv(envToken))
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	listenAddr := strings.TrimSpace(os.Getenv(envListenAddr))
 	if listenAddr == "" {
 		listenAddr = defaultListenAddr
 	}
 
-	if baseURL == "" || token == "" {
-		log.Fatalf("環境変数 %s と %s を設定してください", envBaseURL, envToken)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("設定の読み込みに失敗しました: %v", err)
+	}
+
+	rateLimitBackend, err := newRateLimitBackend(cfg.RateLimit)
+	if err != nil {
+		log.Fatalf("レートリミッターの初期化に失敗しました: %v", err)
 	}
 
-	target, err := url.Parse(baseURL)
+	cacheCfg := loadCacheConfigFromEnv()
+	cacheStore, err := newCacheStore(cacheCfg)
 	if err != nil {
-		log.Fatalf("ベースURLの解析に失敗しました: %v", err)
+		log.Fatalf("キャッシュの初期化に失敗しました: %v", err)
 	}
 
-	proxy := newMistProxy(target, token)
+	policyFile := strings.TrimSpace(os.Getenv(envPolicyFile))
+	policies, err := newPolicyStore(policyFile)
+	if err != nil {
+		log.Fatalf("ポリシーの初期化に失敗しました: %v", err)
+	}
+	watchPolicyReloads(policyFile, policies)
 
 	mux := http.NewServeMux()
-	mux.Handle("/health", http.HandlerFunc(healthHandler))
-	mux.Handle("/", proxy)
+	mux.Handle("/metrics", metricsHandler())
+
+	if len(cfg.Tenants) > 0 {
+		reg, err := newTenantRegistry(cfg.Tenants)
+		if err != nil {
+			log.Fatalf("テナント設定の読み込みに失敗しました: %v", err)
+		}
+		startTenantHealthChecks(reg, time.Minute)
+
+		mux.Handle("/health", tenantHealthHandler(reg))
+		mux.Handle("/", tenantAuthMiddleware(policyMiddleware(cachingMiddleware(newTenantProxy(), cacheStore, cacheCfg), policies), reg))
+
+		log.Printf("Mist API プロキシを %s で待ち受け (マルチテナント: %d件)", listenAddr, len(reg.all))
+	} else {
+		baseURL := strings.TrimSpace(os.Getenv(envBaseURL))
+		token := strings.TrimSpace(os.Getenv(envToken))
+		if baseURL == "" || token == "" {
+			log.Fatalf("環境変数 %s と %s を設定してください (または %s でテナントを設定してください)", envBaseURL, envToken, envConfigFile)
+		}
+
+		target, err := url.Parse(baseURL)
+		if err != nil {
+			log.Fatalf("ベースURLの解析に失敗しました: %v", err)
+		}
+
+		mux.Handle("/health", http.HandlerFunc(healthHandler))
+		mux.Handle("/", policyMiddleware(cachingMiddleware(newMistProxy(target, token), cacheStore, cacheCfg), policies))
+
+		log.Printf("Mist API プロキシを %s で待ち受け (base: %s)", listenAddr, target.Redacted())
+	}
+
+	handler := rateLimitMiddleware(mux, rateLimitBackend, cfg.RateLimit)
+	handler = metricsMiddleware(handler)
 
 	server := &http.Server{
 		Addr:              listenAddr,
-		Handler:           loggingMiddleware(mux),
+		Handler:           requestIDMiddleware(loggingMiddleware(handler)),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
@@ -66,7 +109,6 @@ func main() {
 		close(idleConnsClosed)
 	}()
 
-	log.Printf("Mist API プロキシを %s で待ち受け (base: %s)", listenAddr, target.Redacted())
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("サーバー起動に失敗しました: %v", err)
 	}
@@ -84,11 +126,12 @@ func newMistProxy(target *url.URL, token string) http.Handler {
 		req.Host = target.Host
 		req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
 		req.Header.Set("Accept", "application/json")
-		stripHopHeaders(req.Header)
+		stripHopHeaders(req.Header, isWebSocketUpgrade(req))
 	}
 
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		stripHopHeaders(resp.Header)
+		stripHopHeaders(resp.Header, isWebSocketUpgrade(resp.Request))
+		recordUpstreamStatus(resp.Request, resp.StatusCode)
 		return nil
 	}
 
@@ -100,7 +143,11 @@ func newMistProxy(target *url.URL, token string) http.Handler {
 	return proxy
 }
 
-func stripHopHeaders(header http.Header) {
+// stripHopHeaders removes hop-by-hop headers that must not be forwarded
+// between the wrapper and Mist. When preserveUpgrade is set, Connection and
+// Upgrade are left alone so WebSocket upgrades to Mist's streaming API keep
+// working end to end.
+func stripHopHeaders(header http.Header, preserveUpgrade bool) {
 	for _, h := range []string{
 		"Connection",
 		"Keep-Alive",
@@ -111,6 +158,9 @@ func stripHopHeaders(header http.Header) {
 		"Transfer-Encoding",
 		"Upgrade",
 	} {
+		if preserveUpgrade && (h == "Connection" || h == "Upgrade") {
+			continue
+		}
 		header.Del(h)
 	}
 }
@@ -119,28 +169,3 @@ func healthHandler(rw http.ResponseWriter, _ *http.Request) {
 	rw.Header().Set("Content-Type", "application/json")
 	_, _ = rw.Write([]byte(`{"status":"ok"}`))
 }
-
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		start := time.Now()
-		remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			remoteIP = req.RemoteAddr
-		}
-
-		lrw := &loggingResponseWriter{ResponseWriter: rw, statusCode: http.StatusOK}
-		next.ServeHTTP(lrw, req)
-
-		log.Printf("%s %s %d %s from %s", req.Method, req.URL.String(), lrw.statusCode, time.Since(start), remoteIP)
-	})
-}
-
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-	lrw.statusCode = code
-	lrw.ResponseWriter.WriteHeader(code)
-}
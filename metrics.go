@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mist_proxy_http_requests_total",
+		Help: "Total HTTP requests handled by the wrapper, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	upstreamLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mist_proxy_upstream_latency_seconds",
+		Help:    "Latency of requests served by the wrapper, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	rateLimitRejectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mist_proxy_rate_limit_rejections_total",
+		Help: "Total requests rejected by the rate limiter.",
+	})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mist_proxy_cache_requests_total",
+		Help: "Total cache lookups by result (hit, stale, miss, bypass).",
+	}, []string{"result"})
+
+	inFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mist_proxy_in_flight_requests",
+		Help: "Requests currently being handled by the wrapper.",
+	})
+)
+
+// metricsHandler exposes the registered collectors on /metrics for
+// Prometheus to scrape.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// metricsMiddleware records request totals, request latency, and
+// in-flight request counts. The latency observed into
+// mist_proxy_upstream_latency_seconds is the time spent in the whole
+// handler chain below this middleware, so cache hits and rate-limit
+// rejections are included, not only the round-trip to Mist. WebSocket
+// upgrades are passed through untouched so the ResponseWriter keeps
+// satisfying http.Hijacker.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if isWebSocketUpgrade(req) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		inFlightRequests.Inc()
+		defer inFlightRequests.Dec()
+
+		start := time.Now()
+		mrw := &metricsResponseWriter{ResponseWriter: rw, statusCode: http.StatusOK}
+		next.ServeHTTP(mrw, req)
+
+		route := routeLabel(req.URL.Path)
+		httpRequestsTotal.WithLabelValues(req.Method, route, strconv.Itoa(mrw.statusCode)).Inc()
+		upstreamLatencySeconds.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeLabel collapses a request path down to its first two segments, so
+// IDs in paths like /api/v1/sites/<site-id>/devices don't blow up metric
+// cardinality.
+func routeLabel(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	if len(parts) == 1 && parts[0] == "" {
+		return "/"
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (mrw *metricsResponseWriter) WriteHeader(code int) {
+	mrw.statusCode = code
+	mrw.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPolicyFile = "MIST_POLICY_FILE"
+
+// PolicyRule allows or denies requests matching Methods and Path (a
+// filepath.Match-style glob), optionally scoped to a subset of tenants.
+type PolicyRule struct {
+	Methods []string `yaml:"methods"`
+	Path    string   `yaml:"path"`
+	Effect  string   `yaml:"effect"` // "allow" or "deny"
+	Tenants []string `yaml:"tenants"`
+}
+
+// PolicyConfig is the allow-list evaluated before a request reaches the
+// reverse proxy. Rules are evaluated in order; the first match wins. When
+// nothing matches, DefaultEffect applies.
+type PolicyConfig struct {
+	DefaultEffect string       `yaml:"default_effect"`
+	Rules         []PolicyRule `yaml:"rules"`
+}
+
+func (r PolicyRule) matchesMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == "*" || strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PolicyRule) matchesTenant(tenantName string) bool {
+	if len(r.Tenants) == 0 {
+		return true
+	}
+	for _, t := range r.Tenants {
+		if t == tenantName {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *PolicyConfig) decide(method, reqPath, tenantName string) bool {
+	for _, rule := range c.Rules {
+		if !rule.matchesMethod(method) || !rule.matchesTenant(tenantName) {
+			continue
+		}
+		if ok, _ := path.Match(rule.Path, reqPath); ok {
+			return strings.EqualFold(rule.Effect, "allow")
+		}
+	}
+	return strings.EqualFold(c.DefaultEffect, "allow")
+}
+
+// policyStore holds the live PolicyConfig behind an atomic pointer so
+// policyMiddleware can keep serving traffic while reload swaps it out.
+type policyStore struct {
+	cfg atomic.Pointer[PolicyConfig]
+}
+
+func newPolicyStore(filePath string) (*policyStore, error) {
+	s := &policyStore{}
+	if filePath == "" {
+		s.cfg.Store(&PolicyConfig{DefaultEffect: "allow"})
+		return s, nil
+	}
+	if err := s.reload(filePath); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *policyStore) reload(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("ポリシーファイルの読み込みに失敗しました: %w", err)
+	}
+	var cfg PolicyConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("ポリシーファイルの解析に失敗しました: %w", err)
+	}
+	if cfg.DefaultEffect == "" {
+		cfg.DefaultEffect = "deny"
+	}
+	s.cfg.Store(&cfg)
+	return nil
+}
+
+func (s *policyStore) get() *PolicyConfig {
+	return s.cfg.Load()
+}
+
+// watchPolicyReloads reloads the policy file whenever the process receives
+// SIGHUP, so operators can update the allow-list without a restart.
+func watchPolicyReloads(filePath string, store *policyStore) {
+	if filePath == "" {
+		return
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		for range sigCh {
+			if err := store.reload(filePath); err != nil {
+				log.Printf("ポリシーのホットリロードに失敗しました: %v", err)
+				continue
+			}
+			log.Printf("ポリシーをホットリロードしました: %s", filePath)
+		}
+	}()
+}
+
+// policyMiddleware rejects requests the allow-list doesn't permit before
+// they reach the reverse proxy (and, in multi-tenant mode, before the
+// cache or Mist upstream ever see them).
+func policyMiddleware(next http.Handler, store *policyStore) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		tenantName := ""
+		if t, ok := req.Context().Value(tenantContextKey).(*tenant); ok && t != nil {
+			tenantName = t.cfg.Name
+		}
+
+		if !store.get().decide(req.Method, req.URL.Path, tenantName) {
+			writeJSONError(rw, http.StatusForbidden, "path not allowed by policy")
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig configures the rate limiter subsystem. Routes is keyed by
+// path prefix (e.g. "/api/v1/sites/") and the longest matching prefix wins;
+// Default applies when nothing matches. Exempt lists path prefixes that
+// bypass rate limiting entirely, such as "/health".
+type RateLimitConfig struct {
+	Enabled   bool                  `yaml:"enabled"`
+	Backend   string                `yaml:"backend"` // "memory" (default) or "redis"
+	RedisAddr string                `yaml:"redis_addr"`
+	Default   RouteLimit            `yaml:"default"`
+	Routes    map[string]RouteLimit `yaml:"routes"`
+	Exempt    []string              `yaml:"exempt"`
+}
+
+// RouteLimit bounds a client to RPS requests per second (with Burst) and,
+// when positive, an overall DailyQuota requests per day. The memory backend
+// counts the day as a 24-hour window opened by a client's first request;
+// the Redis backend counts per calendar date.
+type RouteLimit struct {
+	RPS        float64 `yaml:"rps"`
+	Burst      int     `yaml:"burst"`
+	DailyQuota int     `yaml:"daily_quota"`
+}
+
+func (c RateLimitConfig) limitFor(path string) RouteLimit {
+	limit := c.Default
+	matched := ""
+	for prefix, rl := range c.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+			limit = rl
+		}
+	}
+	return limit
+}
+
+func (c RateLimitConfig) isExempt(path string) bool {
+	for _, prefix := range c.Exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitResult carries the bookkeeping needed to populate the
+// X-RateLimit-* and Retry-After response headers. Limit always reports the
+// daily-quota ceiling (0 when no daily quota is configured), regardless of
+// which guard rejected the request; the per-second burst ceiling is a
+// separate axis and is reported through BurstLimit instead, so callers
+// parsing X-RateLimit-Limit never see it silently change meaning between
+// requests on the same route.
+type rateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	BurstLimit int
+	Remaining  int
+	ResetAfter time.Duration
+}
+
+// rateLimitBackend is implemented by the in-memory and Redis backends so
+// the wrapper can run as a single instance or scale out behind a shared
+// limiter.
+type rateLimitBackend interface {
+	Allow(key string, limit RouteLimit) (rateLimitResult, error)
+}
+
+func newRateLimitBackend(cfg RateLimitConfig) (rateLimitBackend, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return newMemoryRateLimiter(), nil
+	case "redis":
+		return newRedisRateLimiter(cfg.RedisAddr)
+	default:
+		return nil, fmt.Errorf("未知のレートリミットバックエンドです: %s", cfg.Backend)
+	}
+}
+
+// memoryRateLimiter is a single-instance token-bucket limiter keyed by
+// client identity, with a daily counter kept alongside each bucket that
+// restarts 24 hours after it was opened.
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	limiter  *rate.Limiter
+	dayStart time.Time
+	dayCount int
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{buckets: make(map[string]*memoryBucket)}
+}
+
+func (m *memoryRateLimiter) Allow(key string, limit RouteLimit) (rateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{
+			limiter:  rate.NewLimiter(rate.Limit(limit.RPS), limit.Burst),
+			dayStart: now,
+		}
+		m.buckets[key] = b
+	} else if b.limiter.Limit() != rate.Limit(limit.RPS) || b.limiter.Burst() != limit.Burst {
+		b.limiter.SetLimit(rate.Limit(limit.RPS))
+		b.limiter.SetBurst(limit.Burst)
+	}
+	if now.Sub(b.dayStart) >= 24*time.Hour {
+		b.dayStart = now
+		b.dayCount = 0
+	}
+	resetAfter := 24*time.Hour - now.Sub(b.dayStart)
+
+	if limit.DailyQuota > 0 && b.dayCount >= limit.DailyQuota {
+		return rateLimitResult{Allowed: false, Limit: limit.DailyQuota, BurstLimit: limit.Burst, Remaining: 0, ResetAfter: resetAfter}, nil
+	}
+	if !b.limiter.Allow() {
+		return rateLimitResult{Allowed: false, Limit: limit.DailyQuota, BurstLimit: limit.Burst, Remaining: 0, ResetAfter: time.Second}, nil
+	}
+
+	b.dayCount++
+	remaining := limit.DailyQuota - b.dayCount
+	if limit.DailyQuota <= 0 {
+		remaining = -1
+	}
+	return rateLimitResult{Allowed: true, Limit: limit.DailyQuota, BurstLimit: limit.Burst, Remaining: remaining, ResetAfter: resetAfter}, nil
+}
+
+// clientKey identifies the caller for rate-limiting purposes: their source
+// IP combined with whatever inbound credential they presented, so a shared
+// NAT'd IP doesn't let one misbehaving API key starve the others. The
+// credential is hashed rather than embedded verbatim, since this key ends
+// up in Redis key names (visible via MONITOR, slowlog, RDB/AOF dumps) for
+// the Redis backend.
+func clientKey(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	apiKey := req.Header.Get("Authorization")
+	if apiKey == "" {
+		return ip + "|anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return ip + "|" + hex.EncodeToString(sum[:])
+}
+
+// rateLimitMiddleware enforces per-client-IP and per-inbound-API-key limits
+// before a request is allowed to reach the reverse proxy.
+func rateLimitMiddleware(next http.Handler, backend rateLimitBackend, cfg RateLimitConfig) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !cfg.Enabled || cfg.isExempt(req.URL.Path) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		limit := cfg.limitFor(req.URL.Path)
+		result, err := backend.Allow(clientKey(req), limit)
+		if err != nil {
+			log.Printf("レートリミットの判定に失敗しました: %v", err)
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		if result.Limit > 0 {
+			rw.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(maxInt(result.Remaining, 0)))
+			rw.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(result.ResetAfter.Seconds()), 10))
+		}
+		if result.BurstLimit > 0 {
+			rw.Header().Set("X-RateLimit-Burst", strconv.Itoa(result.BurstLimit))
+		}
+
+		if !result.Allowed {
+			rateLimitRejectionsTotal.Inc()
+			rw.Header().Set("Retry-After", strconv.FormatInt(int64(result.ResetAfter.Seconds()), 10))
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(rw).Encode(map[string]string{"error": "rate limit exceeded"})
+			return
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestClientKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/self", nil)
+	req.RemoteAddr = "203.0.113.5:54321"
+
+	anon := clientKey(req)
+	if anon != "203.0.113.5|anonymous" {
+		t.Fatalf("got %q, want anonymous key with bare IP", anon)
+	}
+
+	req.Header.Set("Authorization", "Token secret-value")
+	withAuth := clientKey(req)
+	if withAuth == anon {
+		t.Fatal("key should change once a credential is presented")
+	}
+	if indexOf(withAuth, "secret-value") != -1 {
+		t.Fatalf("key %q must not embed the raw credential", withAuth)
+	}
+
+	req2 := httptest.NewRequest(http.MethodGet, "/api/v1/self", nil)
+	req2.RemoteAddr = "203.0.113.5:9999"
+	req2.Header.Set("Authorization", "Token secret-value")
+	if got := clientKey(req2); got != withAuth {
+		t.Fatalf("same IP+credential from a different source port should hash to the same key, got %q and %q", withAuth, got)
+	}
+}
+
+func indexOf(haystack, needle string) int {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if haystack[i:i+len(needle)] == needle {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestMemoryRateLimiterAllow(t *testing.T) {
+	limiter := newMemoryRateLimiter()
+	limit := RouteLimit{RPS: 100, Burst: 10, DailyQuota: 3}
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow("client-a", limit)
+		if err != nil {
+			t.Fatalf("Allow returned error: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("request %d should be allowed within the daily quota", i+1)
+		}
+		if result.Limit != limit.DailyQuota {
+			t.Fatalf("Limit = %d, want daily quota %d", result.Limit, limit.DailyQuota)
+		}
+		if result.BurstLimit != limit.Burst {
+			t.Fatalf("BurstLimit = %d, want %d", result.BurstLimit, limit.Burst)
+		}
+	}
+
+	result, err := limiter.Allow("client-a", limit)
+	if err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	if result.Allowed {
+		t.Fatal("4th request should be rejected once the daily quota is exhausted")
+	}
+	if result.Limit != limit.DailyQuota {
+		t.Fatalf("rejected result Limit = %d, want daily quota %d (consistent with the allowed case)", result.Limit, limit.DailyQuota)
+	}
+
+	other := RouteLimit{RPS: 1, Burst: 1, DailyQuota: 0}
+	limiter2 := newMemoryRateLimiter()
+	if _, err := limiter2.Allow("client-b", other); err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	burstResult, err := limiter2.Allow("client-b", other)
+	if err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	if burstResult.Allowed {
+		t.Fatal("second immediate request should be rejected by the burst bucket")
+	}
+	if burstResult.Limit != other.DailyQuota {
+		t.Fatalf("burst-rejected result Limit = %d, want daily quota %d, not the burst ceiling", burstResult.Limit, other.DailyQuota)
+	}
+	if burstResult.BurstLimit != other.Burst {
+		t.Fatalf("burst-rejected result BurstLimit = %d, want %d", burstResult.BurstLimit, other.Burst)
+	}
+}
+
+func TestRedisRateLimiterAllow(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run: %v", err)
+	}
+	defer mr.Close()
+
+	limiter, err := newRedisRateLimiter(mr.Addr())
+	if err != nil {
+		t.Fatalf("newRedisRateLimiter: %v", err)
+	}
+
+	limit := RouteLimit{RPS: 100, Burst: 100, DailyQuota: 2}
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow("client-a", limit)
+		if err != nil {
+			t.Fatalf("Allow returned error: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("request %d should be allowed within the daily quota", i+1)
+		}
+		if result.Limit != limit.DailyQuota {
+			t.Fatalf("Limit = %d, want daily quota %d", result.Limit, limit.DailyQuota)
+		}
+	}
+
+	result, err := limiter.Allow("client-a", limit)
+	if err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	if result.Allowed {
+		t.Fatal("3rd request should be rejected once the daily quota is exhausted")
+	}
+	if result.Limit != limit.DailyQuota {
+		t.Fatalf("rejected result Limit = %d, want daily quota %d", result.Limit, limit.DailyQuota)
+	}
+
+	burstLimit := RouteLimit{RPS: 1, Burst: 1, DailyQuota: 0}
+	if _, err := limiter.Allow("client-b", burstLimit); err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	burstResult, err := limiter.Allow("client-b", burstLimit)
+	if err != nil {
+		t.Fatalf("Allow returned error: %v", err)
+	}
+	if burstResult.Allowed {
+		t.Fatal("second immediate request should be rejected by the per-second counter")
+	}
+	if burstResult.Limit != burstLimit.DailyQuota {
+		t.Fatalf("burst-rejected result Limit = %d, want daily quota %d, not the burst ceiling", burstResult.Limit, burstLimit.DailyQuota)
+	}
+	if burstResult.BurstLimit != burstLimit.Burst {
+		t.Fatalf("burst-rejected result BurstLimit = %d, want %d", burstResult.BurstLimit, burstLimit.Burst)
+	}
+}
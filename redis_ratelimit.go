@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRateLimiter backs the rate limiter with Redis so multiple wrapper
+// instances behind a load balancer share one set of counters. It uses
+// fixed-window counters (one key per client per second, one per client per
+// day) rather than a true token bucket, which is an acceptable trade-off
+// for a distributed limiter. Each one-second window admits up to Burst
+// requests (at least one); RPS is not used by this backend.
+type redisRateLimiter struct {
+	client *redis.Client
+}
+
+func newRedisRateLimiter(addr string) (*redisRateLimiter, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("redis_addr が設定されていません")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	return &redisRateLimiter{client: client}, nil
+}
+
+func (r *redisRateLimiter) Allow(key string, limit RouteLimit) (rateLimitResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	var dayCount int64
+	dayTTL := 24 * time.Hour
+	if limit.DailyQuota > 0 {
+		dayKey := fmt.Sprintf("mist-proxy:quota:%s:%s", key, now.Format("2006-01-02"))
+		count, err := r.client.Incr(ctx, dayKey).Result()
+		if err != nil {
+			return rateLimitResult{}, fmt.Errorf("redisへのクォータ問い合わせに失敗しました: %w", err)
+		}
+		dayCount = count
+		if count == 1 {
+			r.client.Expire(ctx, dayKey, 24*time.Hour)
+		} else if ttl, err := r.client.TTL(ctx, dayKey).Result(); err == nil && ttl > 0 {
+			dayTTL = ttl
+		}
+		if int(dayCount) > limit.DailyQuota {
+			return rateLimitResult{Allowed: false, Limit: limit.DailyQuota, BurstLimit: limit.Burst, Remaining: 0, ResetAfter: dayTTL}, nil
+		}
+	}
+
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	secKey := fmt.Sprintf("mist-proxy:rps:%s:%d", key, now.Unix())
+	secCount, err := r.client.Incr(ctx, secKey).Result()
+	if err != nil {
+		return rateLimitResult{}, fmt.Errorf("redisへのレート問い合わせに失敗しました: %w", err)
+	}
+	if secCount == 1 {
+		r.client.Expire(ctx, secKey, time.Second)
+	}
+	if int(secCount) > burst {
+		return rateLimitResult{Allowed: false, Limit: limit.DailyQuota, BurstLimit: burst, Remaining: 0, ResetAfter: time.Second}, nil
+	}
+
+	if limit.DailyQuota <= 0 {
+		return rateLimitResult{Allowed: true, Limit: 0, BurstLimit: burst, Remaining: -1}, nil
+	}
+	return rateLimitResult{Allowed: true, Limit: limit.DailyQuota, BurstLimit: burst, Remaining: limit.DailyQuota - int(dayCount), ResetAfter: dayTTL}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const headerRequestID = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDMiddleware ensures every request carries an X-Request-ID,
+// generating one when the caller didn't send it, so logs here and Mist's
+// own access logs can be correlated.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(headerRequestID)
+		if id == "" {
+			id = newRequestID()
+			req.Header.Set(headerRequestID, id)
+		}
+		rw.Header().Set(headerRequestID, id)
+
+		ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+		next.ServeHTTP(rw, req.WithContext(ctx))
+	})
+}
+
+func requestIDFrom(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type contextKey string
+
+const tenantContextKey contextKey = "tenant"
+
+// TenantConfig maps a credential callers present to the wrapper onto a
+// specific upstream Mist org: its base URL and API token, plus the org IDs
+// and path patterns that credential is allowed to touch.
+type TenantConfig struct {
+	Name          string   `yaml:"name"`
+	WrapperKey    string   `yaml:"wrapper_key"`
+	BaseURL       string   `yaml:"base_url"`
+	Token         string   `yaml:"token"`
+	AllowedOrgIDs []string `yaml:"allowed_org_ids"`
+	AllowedPaths  []string `yaml:"allowed_paths"`
+}
+
+// tenant is a TenantConfig with its base URL pre-parsed and the health
+// status from the most recent background probe.
+type tenant struct {
+	cfg    TenantConfig
+	target *url.URL
+
+	mu      sync.RWMutex
+	healthy bool
+	checked time.Time
+}
+
+// tenantRegistry resolves inbound wrapper credentials to upstream tenants.
+type tenantRegistry struct {
+	byWrapperKey map[string]*tenant
+	all          []*tenant
+}
+
+func newTenantRegistry(tenants []TenantConfig) (*tenantRegistry, error) {
+	reg := &tenantRegistry{byWrapperKey: make(map[string]*tenant)}
+	for _, tc := range tenants {
+		if tc.WrapperKey == "" || tc.BaseURL == "" || tc.Token == "" {
+			return nil, fmt.Errorf("テナント %q の設定が不完全です (wrapper_key/base_url/token が必要です)", tc.Name)
+		}
+		target, err := url.Parse(tc.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("テナント %q のbase_urlの解析に失敗しました: %w", tc.Name, err)
+		}
+		t := &tenant{cfg: tc, target: target}
+		reg.byWrapperKey[tc.WrapperKey] = t
+		reg.all = append(reg.all, t)
+	}
+	return reg, nil
+}
+
+func (r *tenantRegistry) lookup(wrapperKey string) (*tenant, bool) {
+	if wrapperKey == "" {
+		return nil, false
+	}
+	t, ok := r.byWrapperKey[wrapperKey]
+	return t, ok
+}
+
+// allowsPath reports whether this tenant's credential may reach the given
+// request path, per its configured glob patterns. No patterns configured
+// means no restriction beyond the tenant boundary itself.
+func (t *tenant) allowsPath(reqPath string) bool {
+	if len(t.cfg.AllowedPaths) == 0 {
+		return true
+	}
+	for _, pattern := range t.cfg.AllowedPaths {
+		if ok, _ := path.Match(pattern, reqPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrg reports whether this tenant's credential may touch the org
+// referenced by reqPath. Requests that aren't scoped to a particular org
+// (reqPath has no /orgs/{org_id}/ segment) are let through; AllowedOrgIDs
+// only narrows access to orgs the credential is explicitly permitted to
+// reach.
+func (t *tenant) allowsOrg(reqPath string) bool {
+	if len(t.cfg.AllowedOrgIDs) == 0 {
+		return true
+	}
+	orgID := orgIDFromPath(reqPath)
+	if orgID == "" {
+		return true
+	}
+	for _, id := range t.cfg.AllowedOrgIDs {
+		if id == orgID {
+			return true
+		}
+	}
+	return false
+}
+
+// orgIDFromPath extracts the {org_id} segment from Mist paths shaped like
+// /api/v1/orgs/{org_id}/..., returning "" when the path isn't org-scoped.
+func orgIDFromPath(reqPath string) string {
+	const marker = "/orgs/"
+	idx := strings.Index(reqPath, marker)
+	if idx == -1 {
+		return ""
+	}
+	rest := strings.TrimPrefix(reqPath[idx+len(marker):], "/")
+	if end := strings.Index(rest, "/"); end != -1 {
+		rest = rest[:end]
+	}
+	return rest
+}
+
+func (t *tenant) setHealthy(ok bool) {
+	t.mu.Lock()
+	t.healthy = ok
+	t.checked = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *tenant) status() (healthy bool, checked time.Time) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.healthy, t.checked
+}
+
+// startTenantHealthChecks periodically probes each tenant's Mist upstream
+// and records whether it responded. It returns immediately; probing runs in
+// background goroutines until the process exits.
+func startTenantHealthChecks(reg *tenantRegistry, interval time.Duration) {
+	for _, t := range reg.all {
+		t := t
+		go func() {
+			client := &http.Client{Timeout: 5 * time.Second}
+			for {
+				probeTenant(client, t)
+				time.Sleep(interval)
+			}
+		}()
+	}
+}
+
+func probeTenant(client *http.Client, t *tenant) {
+	probeURL := strings.TrimRight(t.target.String(), "/") + "/api/v1/self"
+	req, err := http.NewRequest(http.MethodGet, probeURL, nil)
+	if err != nil {
+		t.setHealthy(false)
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", t.cfg.Token))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.setHealthy(false)
+		return
+	}
+	defer resp.Body.Close()
+	t.setHealthy(resp.StatusCode < http.StatusInternalServerError)
+}
+
+// tenantAuthMiddleware authenticates the caller against the tenant
+// registry using either a bearer token or the X-Tenant header, then stores
+// the resolved tenant on the request context for the Director to use.
+func tenantAuthMiddleware(next http.Handler, reg *tenantRegistry) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		cred := bearerToken(req.Header.Get("Authorization"))
+		if cred == "" {
+			cred = req.Header.Get("X-Tenant")
+		}
+
+		t, ok := reg.lookup(cred)
+		if !ok {
+			writeJSONError(rw, http.StatusUnauthorized, "unknown tenant credential")
+			return
+		}
+		if !t.allowsPath(req.URL.Path) {
+			writeJSONError(rw, http.StatusForbidden, "path not allowed for this tenant")
+			return
+		}
+		if !t.allowsOrg(req.URL.Path) {
+			writeJSONError(rw, http.StatusForbidden, "org not allowed for this tenant")
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), tenantContextKey, t)
+		next.ServeHTTP(rw, req.WithContext(ctx))
+	})
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if strings.HasPrefix(header, prefix) {
+		return strings.TrimPrefix(header, prefix)
+	}
+	return ""
+}
+
+// newTenantProxy returns a reverse proxy whose upstream target and token
+// are resolved per-request from the tenant stored on the request context by
+// tenantAuthMiddleware.
+func newTenantProxy() http.Handler {
+	director := func(req *http.Request) {
+		t, _ := req.Context().Value(tenantContextKey).(*tenant)
+		if t == nil {
+			return
+		}
+		req.URL.Scheme = t.target.Scheme
+		req.URL.Host = t.target.Host
+		req.URL.Path = singleJoiningSlash(t.target.Path, req.URL.Path)
+		req.Host = t.target.Host
+		req.Header.Set("Authorization", fmt.Sprintf("Token %s", t.cfg.Token))
+		req.Header.Set("Accept", "application/json")
+		stripHopHeaders(req.Header, isWebSocketUpgrade(req))
+	}
+
+	return &httputil.ReverseProxy{
+		Director: director,
+		ModifyResponse: func(resp *http.Response) error {
+			stripHopHeaders(resp.Header, isWebSocketUpgrade(resp.Request))
+			recordUpstreamStatus(resp.Request, resp.StatusCode)
+			return nil
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("プロキシエラー: %v", err)
+			http.Error(rw, "アップストリームへの接続に失敗しました", http.StatusBadGateway)
+		},
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// tenantHealthHandler reports the liveness of the wrapper along with the
+// last known health of every configured tenant's Mist upstream.
+func tenantHealthHandler(reg *tenantRegistry) http.HandlerFunc {
+	return func(rw http.ResponseWriter, _ *http.Request) {
+		type tenantStatus struct {
+			Name    string `json:"name"`
+			Healthy bool   `json:"healthy"`
+			Checked string `json:"checked_at,omitempty"`
+		}
+
+		statuses := make([]tenantStatus, 0, len(reg.all))
+		for _, t := range reg.all {
+			healthy, checked := t.status()
+			ts := tenantStatus{Name: t.cfg.Name, Healthy: healthy}
+			if !checked.IsZero() {
+				ts.Checked = checked.UTC().Format(time.RFC3339)
+			}
+			statuses = append(statuses, ts)
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(map[string]any{
+			"status":  "ok",
+			"tenants": statuses,
+		})
+	}
+}
+
+func writeJSONError(rw http.ResponseWriter, status int, msg string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	fmt.Fprintf(rw, `{"error":%q}`, msg)
+}
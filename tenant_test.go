@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestTenantAllowsPath(t *testing.T) {
+	tn := &tenant{cfg: TenantConfig{AllowedPaths: []string{"/api/v1/sites/*", "/api/v1/self"}}}
+
+	cases := map[string]bool{
+		"/api/v1/self":          true,
+		"/api/v1/sites/abc123":  true,
+		"/api/v1/orgs/abc123":   false,
+		"/api/v1/sites/abc/sub": false,
+	}
+	for path, want := range cases {
+		if got := tn.allowsPath(path); got != want {
+			t.Errorf("allowsPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	unrestricted := &tenant{cfg: TenantConfig{}}
+	if !unrestricted.allowsPath("/anything/at/all") {
+		t.Error("no AllowedPaths configured should mean no restriction")
+	}
+}
+
+func TestTenantAllowsOrg(t *testing.T) {
+	tn := &tenant{cfg: TenantConfig{AllowedOrgIDs: []string{"org-1", "org-2"}}}
+
+	if !tn.allowsOrg("/api/v1/orgs/org-1/sites") {
+		t.Error("request scoped to an allowed org should be permitted")
+	}
+	if tn.allowsOrg("/api/v1/orgs/org-3/sites") {
+		t.Error("request scoped to a disallowed org should be rejected")
+	}
+	if !tn.allowsOrg("/api/v1/self") {
+		t.Error("a request with no /orgs/{id}/ segment should not be blocked by AllowedOrgIDs")
+	}
+
+	unrestricted := &tenant{cfg: TenantConfig{}}
+	if !unrestricted.allowsOrg("/api/v1/orgs/org-9/sites") {
+		t.Error("no AllowedOrgIDs configured should mean no restriction")
+	}
+}
+
+func TestOrgIDFromPath(t *testing.T) {
+	cases := map[string]string{
+		"/api/v1/orgs/abc-123/sites": "abc-123",
+		"/api/v1/orgs/abc-123":       "abc-123",
+		"/api/v1/orgs/":              "",
+		"/api/v1/self":               "",
+	}
+	for path, want := range cases {
+		if got := orgIDFromPath(path); got != want {
+			t.Errorf("orgIDFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
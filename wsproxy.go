@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// wsIdleTimeout bounds how long a WebSocket passthrough connection may sit
+// without any traffic before it is closed, since hijacked connections
+// bypass the server's own read/write timeouts.
+const wsIdleTimeout = 5 * time.Minute
+
+// isWebSocketUpgrade reports whether req is asking to upgrade this HTTP
+// connection to WebSocket, per RFC 6455. Mist's streaming API
+// (/api-ws/v1/stream) is reached this way.
+func isWebSocketUpgrade(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// wsLoggingConn wraps a hijacked connection so WebSocket passthrough gets a
+// duration/byte log line when it closes, much as loggingMiddleware logs each
+// ordinary request, and enforces wsIdleTimeout by pushing the read deadline
+// forward on every read and write.
+type wsLoggingConn struct {
+	net.Conn
+	path     string
+	start    time.Time
+	bytesIn  int64
+	bytesOut int64
+	closed   int32
+}
+
+func newWSLoggingConn(conn net.Conn, path string) *wsLoggingConn {
+	return &wsLoggingConn{Conn: conn, path: path, start: time.Now()}
+}
+
+func (c *wsLoggingConn) Read(b []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	return n, err
+}
+
+func (c *wsLoggingConn) Write(b []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	return n, err
+}
+
+func (c *wsLoggingConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		log.Printf("WebSocket切断 %s duration=%s in=%dB out=%dB",
+			c.path, time.Since(c.start), atomic.LoadInt64(&c.bytesIn), atomic.LoadInt64(&c.bytesOut))
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestWebSocketPassthrough drives a real WebSocket handshake and message
+// exchange through newMistProxy + loggingMiddleware against a mock Mist
+// upstream, guarding against regressions in stripHopHeaders and
+// loggingResponseWriter's Hijack support.
+func TestWebSocketPassthrough(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var gotAuth string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotAuth = req.Header.Get("Authorization")
+		conn, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			t.Errorf("upstream upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, append([]byte("echo:"), msg...)); err != nil {
+				return
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatalf("parse upstream url: %v", err)
+	}
+
+	proxy := httptest.NewServer(loggingMiddleware(newMistProxy(target, "test-token")))
+	defer proxy.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(proxy.URL, "http") + "/api-ws/v1/stream"
+	conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial through proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write message: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	if got, want := string(msg), "echo:hello"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+
+	if want := "Token test-token"; gotAuth != want {
+		t.Errorf("upstream saw Authorization %q, want %q", gotAuth, want)
+	}
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api-ws/v1/stream", nil)
+	if isWebSocketUpgrade(req) {
+		t.Fatal("plain GET request should not be treated as a WebSocket upgrade")
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if !isWebSocketUpgrade(req) {
+		t.Fatal("request with Connection/Upgrade headers should be treated as a WebSocket upgrade")
+	}
+}